@@ -0,0 +1,177 @@
+package processors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stellar/go/ingest"
+	"github.com/stellar/go/support/errors"
+	"github.com/stellar/go/xdr"
+)
+
+// EntryAdapter teaches BatchChangeProcessor how to convert a single ledger
+// entry type to/from its history row and how to batch-write it.
+// BatchChangeProcessor only handles entries that are purely created or
+// purely removed within a commit window, which covers claimable balances
+// today; sibling processors for entities that can also be updated in place
+// (offers, trustlines, liquidity pools, accounts) will need Commit taught
+// about the Pre != nil && Post != nil case before they can migrate onto it.
+type EntryAdapter[Row any] interface {
+	LedgerEntryType() xdr.LedgerEntryType
+	ToRow(entry *xdr.LedgerEntry) (Row, error)
+	RowID(row Row) string
+	Insert(ctx context.Context, rows []Row) error
+	Delete(ctx context.Context, ids []string) (int64, error)
+}
+
+// BatchChangeProcessor buffers ingest.Change entries for a single ledger
+// entry type and, on Commit, batches them into inserts and deletes via an
+// EntryAdapter. It generalizes the buffer/split/batch-write shape shared by
+// ClaimableBalancesChangeProcessor and its sibling per-entity processors.
+type BatchChangeProcessor[Row any] struct {
+	adapter          EntryAdapter[Row]
+	cache            ChangeCompactor
+	compactorFactory CompactorFactory
+
+	// onCreate, if set, runs once per created row before Insert is called,
+	// e.g. so the claimable balances adapter can queue claimant rows
+	// alongside the balance itself.
+	onCreate func(row Row) error
+	// onRemove, if set, runs once per removed row id and may skip it from
+	// the batch delete, e.g. so the claimable balances adapter can consult
+	// its cuckoo filter before hitting Postgres.
+	onRemove func(id string) (skip bool, err error)
+
+	// allowPartialDelete, when true, tolerates adapter.Delete affecting
+	// fewer rows than requested instead of raising a state error. Set this
+	// when onRemove's skip decision is itself probabilistic (e.g. backed
+	// by a cuckoo filter): a false positive lets an id through that was
+	// never actually indexed, so the delete legitimately affects zero rows
+	// for it. It does NOT tolerate affecting *more* rows than requested,
+	// which would indicate a real bug regardless.
+	allowPartialDelete bool
+}
+
+// NewBatchChangeProcessor constructs a BatchChangeProcessor for the given
+// adapter. onCreate and onRemove may be nil.
+func NewBatchChangeProcessor[Row any](
+	adapter EntryAdapter[Row],
+	compactorFactory CompactorFactory,
+	onCreate func(row Row) error,
+	onRemove func(id string) (skip bool, err error),
+	allowPartialDelete bool,
+) *BatchChangeProcessor[Row] {
+	if compactorFactory == nil {
+		compactorFactory = NewInMemoryCompactorFactory()
+	}
+	p := &BatchChangeProcessor[Row]{
+		adapter:            adapter,
+		compactorFactory:   compactorFactory,
+		onCreate:           onCreate,
+		onRemove:           onRemove,
+		allowPartialDelete: allowPartialDelete,
+	}
+	p.reset()
+	return p
+}
+
+func (p *BatchChangeProcessor[Row]) Name() string {
+	return fmt.Sprintf("processors.BatchChangeProcessor[%T]", *new(Row))
+}
+
+func (p *BatchChangeProcessor[Row]) reset() {
+	p.cache = p.compactorFactory()
+}
+
+func (p *BatchChangeProcessor[Row]) ProcessChange(ctx context.Context, change ingest.Change) error {
+	if change.Type != p.adapter.LedgerEntryType() {
+		return nil
+	}
+
+	if err := p.cache.AddChange(change); err != nil {
+		return errors.Wrap(err, "error adding to ledgerCache")
+	}
+
+	if p.cache.Size() > maxBatchSize {
+		if err := p.Commit(ctx); err != nil {
+			return errors.Wrap(err, "error in Commit")
+		}
+	}
+
+	return nil
+}
+
+func (p *BatchChangeProcessor[Row]) Commit(ctx context.Context) error {
+	defer p.reset()
+
+	var (
+		rowsToInsert []Row
+		idsToDelete  []string
+	)
+
+	changes, err := p.cache.GetChanges()
+	if err != nil {
+		return errors.Wrap(err, "error reading changes from compactor")
+	}
+
+	for _, change := range changes {
+		switch {
+		case change.Pre == nil && change.Post != nil:
+			row, err := p.adapter.ToRow(change.Post)
+			if err != nil {
+				return err
+			}
+			if p.onCreate != nil {
+				if err := p.onCreate(row); err != nil {
+					return errors.Wrap(err, "error in onCreate hook")
+				}
+			}
+			rowsToInsert = append(rowsToInsert, row)
+		case change.Pre != nil && change.Post == nil:
+			row, err := p.adapter.ToRow(change.Pre)
+			if err != nil {
+				return err
+			}
+			id := p.adapter.RowID(row)
+			if p.onRemove != nil {
+				skip, err := p.onRemove(id)
+				if err != nil {
+					return errors.Wrap(err, "error in onRemove hook")
+				}
+				if skip {
+					continue
+				}
+			}
+			idsToDelete = append(idsToDelete, id)
+		case change.Pre == nil && change.Post == nil:
+			// Created and removed within the same buffered window; the
+			// ChangeCompactor's Pre/Post cancel out so there's nothing to write.
+			continue
+		default:
+			return fmt.Errorf("invalid change entry for a %s was detected", p.adapter.LedgerEntryType())
+		}
+	}
+
+	if len(rowsToInsert) > 0 {
+		if err := p.adapter.Insert(ctx, rowsToInsert); err != nil {
+			return errors.Wrap(err, "error executing batch insert")
+		}
+	}
+
+	if len(idsToDelete) > 0 {
+		count, err := p.adapter.Delete(ctx, idsToDelete)
+		if err != nil {
+			return errors.Wrap(err, "error executing batch delete")
+		}
+		if count > int64(len(idsToDelete)) || (count != int64(len(idsToDelete)) && !p.allowPartialDelete) {
+			return ingest.NewStateError(errors.Errorf(
+				"%d rows affected when deleting %d %s rows",
+				count,
+				len(idsToDelete),
+				p.adapter.LedgerEntryType(),
+			))
+		}
+	}
+
+	return nil
+}
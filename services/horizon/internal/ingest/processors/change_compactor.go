@@ -0,0 +1,42 @@
+package processors
+
+import "github.com/stellar/go/ingest"
+
+// ChangeCompactor buffers ingest.Change entries keyed by ledger entry and
+// coalesces multiple changes to the same entry into a single net change.
+// inMemoryChangeCompactor (backed by ingest.ChangeCompactor) satisfies this
+// interface; RedisChangeCompactor is an alternate backend that lets several
+// horizon-ingest workers share and dedupe a compactor across processes.
+//
+// GetChanges returns an error (unlike ingest.ChangeCompactor's own method)
+// because the Redis backend can fail on the read; callers must not treat
+// that error the same as "no changes".
+type ChangeCompactor interface {
+	AddChange(change ingest.Change) error
+	GetChanges() ([]ingest.Change, error)
+	Size() int
+}
+
+// CompactorFactory builds a fresh ChangeCompactor for a processor's commit
+// cycle. Processors call it once in reset() rather than constructing the
+// in-memory compactor directly, so operators can pick the backend via
+// config.
+type CompactorFactory func() ChangeCompactor
+
+// inMemoryChangeCompactor adapts ingest.ChangeCompactor (whose GetChanges
+// cannot fail) to the ChangeCompactor interface.
+type inMemoryChangeCompactor struct {
+	*ingest.ChangeCompactor
+}
+
+func (c inMemoryChangeCompactor) GetChanges() ([]ingest.Change, error) {
+	return c.ChangeCompactor.GetChanges(), nil
+}
+
+// NewInMemoryCompactorFactory returns a CompactorFactory backed by
+// ingest.ChangeCompactor, the default single-process behavior.
+func NewInMemoryCompactorFactory() CompactorFactory {
+	return func() ChangeCompactor {
+		return inMemoryChangeCompactor{ingest.NewChangeCompactor()}
+	}
+}
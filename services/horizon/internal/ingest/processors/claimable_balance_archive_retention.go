@@ -0,0 +1,56 @@
+package processors
+
+import (
+	"context"
+	"time"
+
+	"github.com/stellar/go/support/log"
+)
+
+// ArchiveRetentionWorker periodically trims claimable_balances_archive (and
+// claimable_balance_claimants_archive) down to RetentionLedgers worth of
+// history, so operators who archive instead of hard-deleting don't grow
+// those tables forever.
+type ArchiveRetentionWorker struct {
+	q                ArchivingQClaimableBalances
+	retentionLedgers uint32
+	interval         time.Duration
+}
+
+// NewArchiveRetentionWorker builds a worker that trims the archive every
+// interval. retentionLedgers of 0 makes Run a no-op, matching
+// ArchiveConfig.RetentionLedgers's "0 means keep forever".
+func NewArchiveRetentionWorker(q ArchivingQClaimableBalances, retentionLedgers uint32, interval time.Duration) *ArchiveRetentionWorker {
+	return &ArchiveRetentionWorker{q: q, retentionLedgers: retentionLedgers, interval: interval}
+}
+
+// Run trims the archive on every tick until ctx is cancelled. currentLedger
+// reports the ingest system's latest ledger; retention is measured in
+// ledgers rather than wall-clock time so it tracks chain height directly.
+func (w *ArchiveRetentionWorker) Run(ctx context.Context, currentLedger func() uint32) {
+	if w.retentionLedgers == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.trimOnce(ctx, currentLedger())
+		}
+	}
+}
+
+func (w *ArchiveRetentionWorker) trimOnce(ctx context.Context, ledger uint32) {
+	if ledger <= w.retentionLedgers {
+		return
+	}
+	cutoff := ledger - w.retentionLedgers
+	if _, err := w.q.TrimClaimableBalanceArchive(ctx, cutoff); err != nil {
+		log.Ctx(ctx).WithError(err).Warn("error trimming claimable balance archive")
+	}
+}
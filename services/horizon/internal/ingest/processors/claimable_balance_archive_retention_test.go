@@ -0,0 +1,48 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeArchivingQClaimableBalances struct {
+	trimmedAt []uint32
+	trimErr   error
+}
+
+func (f *fakeArchivingQClaimableBalances) ArchiveClaimableBalances(ctx context.Context, ids []string, archivedAtLedger uint32) error {
+	return nil
+}
+
+func (f *fakeArchivingQClaimableBalances) TrimClaimableBalanceArchive(ctx context.Context, olderThanLedger uint32) (int64, error) {
+	if f.trimErr != nil {
+		return 0, f.trimErr
+	}
+	f.trimmedAt = append(f.trimmedAt, olderThanLedger)
+	return 1, nil
+}
+
+func TestArchiveRetentionWorkerTrimOnce(t *testing.T) {
+	q := &fakeArchivingQClaimableBalances{}
+	worker := NewArchiveRetentionWorker(q, 100, time.Minute)
+
+	worker.trimOnce(context.Background(), 50)
+	assert.Empty(t, q.trimmedAt, "ledger below retention window must not trim")
+
+	worker.trimOnce(context.Background(), 150)
+	assert.Equal(t, []uint32{50}, q.trimmedAt)
+}
+
+func TestArchiveRetentionWorkerZeroRetentionIsNoop(t *testing.T) {
+	q := &fakeArchivingQClaimableBalances{}
+	worker := NewArchiveRetentionWorker(q, 0, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	worker.Run(ctx, func() uint32 { return 1000 })
+
+	assert.Empty(t, q.trimmedAt, "RetentionLedgers of 0 must keep the worker from ever trimming")
+}
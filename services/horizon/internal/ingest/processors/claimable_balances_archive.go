@@ -0,0 +1,77 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/stellar/go/services/horizon/internal/db2/history"
+	"github.com/stellar/go/support/errors"
+)
+
+// ArchivingQClaimableBalances extends history.QClaimableBalances with the
+// archive-table operations ClaimableBalancesChangeProcessor needs when
+// ArchiveConfig.Enabled is set. It is a separate interface (rather than an
+// addition to history.QClaimableBalances itself) so a QClaimableBalances
+// implementation that hasn't grown the supporting
+// claimable_balances_archive / claimable_balance_claimants_archive tables
+// yet still satisfies the processor's base requirements; the processor
+// type-asserts for this interface at the point archiving is actually used.
+//
+// Nothing in this package creates the archive tables (or their
+// operator-configurable tablespace, e.g. a cheaper HDD-backed one) or
+// implements these two methods; see ValidateArchiveConfig.
+type ArchivingQClaimableBalances interface {
+	// ArchiveClaimableBalances copies the pre-image of every row in ids
+	// into the archive tables, stamped with archivedAtLedger, before the
+	// caller removes them from the hot tables. archived_tx_hash is left
+	// null by this path: ingest.Change carries no transaction linkage at
+	// the point a ChangeCompactor flushes, so it can't be threaded through
+	// here; it can be backfilled by whatever ingests the raw tx meta.
+	ArchiveClaimableBalances(ctx context.Context, ids []string, archivedAtLedger uint32) error
+
+	// TrimClaimableBalanceArchive deletes archive rows (and their claimant
+	// counterparts) archived at or before olderThanLedger, returning the
+	// number of claimable balance rows removed. Used by
+	// ArchiveRetentionWorker.
+	TrimClaimableBalanceArchive(ctx context.Context, olderThanLedger uint32) (int64, error)
+}
+
+// ArchiveConfig controls whether ClaimableBalancesChangeProcessor archives
+// a balance's pre-image before deleting it, and how long that archive is
+// retained.
+type ArchiveConfig struct {
+	// Enabled turns archival on. When true, the QClaimableBalances passed
+	// to NewClaimableBalancesChangeProcessor must also implement
+	// ArchivingQClaimableBalances.
+	Enabled bool
+	// TablespacePath is the operator-chosen Postgres tablespace (e.g. a
+	// path on cheaper HDD storage) the archive tables are created on by
+	// the accompanying migration. It isn't read by the processor itself;
+	// it's threaded through config so operators have one place that
+	// controls both "archive or not" and "where archived rows live".
+	TablespacePath string
+	// RetentionLedgers bounds how long archived rows are kept before
+	// ArchiveRetentionWorker trims them. Zero means keep forever.
+	RetentionLedgers uint32
+}
+
+// ValidateArchiveConfig reports whether archive can actually be honored by
+// q, so misconfiguration is caught once at startup instead of surfacing
+// mid-ingestion the first time a claimable balance is deleted. Horizon's
+// ingestion bootstrap should call this before constructing
+// NewClaimableBalancesChangeProcessor with WithArchive(archive).
+//
+// As of this package, nothing in db2/history implements
+// ArchivingQClaimableBalances yet -- the migration that creates
+// claimable_balances_archive / claimable_balance_claimants_archive (and the
+// operator tablespace named by TablespacePath) and the concrete
+// ArchiveClaimableBalances/TrimClaimableBalanceArchive implementation still
+// need to land there before ArchiveConfig.Enabled is functional end-to-end.
+func ValidateArchiveConfig(q history.QClaimableBalances, archive ArchiveConfig) error {
+	if !archive.Enabled {
+		return nil
+	}
+	if _, ok := q.(ArchivingQClaimableBalances); !ok {
+		return errors.New("claimable balance archiving is enabled but the configured QClaimableBalances does not implement ArchivingQClaimableBalances (the db2/history migration and implementation have not landed yet)")
+	}
+	return nil
+}
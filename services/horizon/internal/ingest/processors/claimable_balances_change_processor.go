@@ -2,7 +2,6 @@ package processors
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/stellar/go/ingest"
 	"github.com/stellar/go/services/horizon/internal/db2/history"
@@ -10,129 +9,244 @@ import (
 	"github.com/stellar/go/xdr"
 )
 
+// ClaimableBalancesChangeProcessor is a thin EntryAdapter-backed wrapper
+// around BatchChangeProcessor[history.ClaimableBalance]. It exists (rather
+// than just using BatchChangeProcessor directly) so the claimable-balance-
+// specific behavior can live in claimableBalanceAdapter while still
+// presenting the same Name/ProcessChange/Commit surface callers have always
+// used.
 type ClaimableBalancesChangeProcessor struct {
-	encodingBuffer                *xdr.EncodingBuffer
-	qClaimableBalances            history.QClaimableBalances
-	cache                         *ingest.ChangeCompactor
-	claimantsInsertBuilder        history.ClaimableBalanceClaimantBatchInsertBuilder
-	claimableBalanceInsertBuilder history.ClaimableBalanceBatchInsertBuilder
+	adapter *claimableBalanceAdapter
+	batch   *BatchChangeProcessor[history.ClaimableBalance]
 }
 
-func NewClaimableBalancesChangeProcessor(Q history.QClaimableBalances) *ClaimableBalancesChangeProcessor {
-	p := &ClaimableBalancesChangeProcessor{
-		encodingBuffer:     xdr.NewEncodingBuffer(),
-		qClaimableBalances: Q,
-	}
-	p.reset()
-	return p
+// ClaimableBalancesChangeProcessorOption configures optional behavior on
+// NewClaimableBalancesChangeProcessor. Callers that only need the original
+// behavior can call NewClaimableBalancesChangeProcessor(Q) with no options;
+// archiving, a non-default compactor, a pre-populated cuckoo filter, and the
+// current ledger are all opt-in.
+type ClaimableBalancesChangeProcessorOption func(*claimableBalancesChangeProcessorConfig)
+
+type claimableBalancesChangeProcessorConfig struct {
+	ledgerSequence   uint32
+	archive          ArchiveConfig
+	compactorFactory CompactorFactory
+	seenFilter       *CuckooFilter
 }
 
-func (p *ClaimableBalancesChangeProcessor) Name() string {
-	return "processors.ClaimableBalancesChangeProcessor"
+// WithLedgerSequence records the ledger this processor is running against,
+// used to stamp archived rows (see WithArchive) with the ledger they were
+// removed at.
+func WithLedgerSequence(ledgerSequence uint32) ClaimableBalancesChangeProcessorOption {
+	return func(c *claimableBalancesChangeProcessorConfig) {
+		c.ledgerSequence = ledgerSequence
+	}
 }
 
-func (p *ClaimableBalancesChangeProcessor) reset() {
-	p.cache = ingest.NewChangeCompactor()
-	p.claimantsInsertBuilder = p.qClaimableBalances.NewClaimableBalanceClaimantBatchInsertBuilder()
-	p.claimableBalanceInsertBuilder = p.qClaimableBalances.NewClaimableBalanceBatchInsertBuilder()
+// WithArchive enables archiving deleted claimable balances instead of hard
+// deleting them; see ArchiveConfig.
+func WithArchive(archive ArchiveConfig) ClaimableBalancesChangeProcessorOption {
+	return func(c *claimableBalancesChangeProcessorConfig) {
+		c.archive = archive
+	}
 }
 
-func (p *ClaimableBalancesChangeProcessor) ProcessChange(ctx context.Context, change ingest.Change) error {
-	if change.Type != xdr.LedgerEntryTypeClaimableBalance {
-		return nil
+// WithCompactorFactory overrides the default in-memory ChangeCompactor, e.g.
+// with NewRedisCompactorFactory.
+func WithCompactorFactory(compactorFactory CompactorFactory) ClaimableBalancesChangeProcessorOption {
+	return func(c *claimableBalancesChangeProcessorConfig) {
+		c.compactorFactory = compactorFactory
 	}
+}
 
-	err := p.cache.AddChange(change)
-	if err != nil {
-		return errors.Wrap(err, "error adding to ledgerCache")
+// WithSeenFilter supplies a pre-populated cuckoo filter (see
+// RebuildClaimableBalanceFilter). Without one, the processor starts with an
+// empty filter that it never consults, so every delete goes to Postgres.
+func WithSeenFilter(seenFilter *CuckooFilter) ClaimableBalancesChangeProcessorOption {
+	return func(c *claimableBalancesChangeProcessorConfig) {
+		c.seenFilter = seenFilter
 	}
+}
 
-	if p.cache.Size() > maxBatchSize {
-		err = p.Commit(ctx)
-		if err != nil {
-			return errors.Wrap(err, "error in Commit")
-		}
+func NewClaimableBalancesChangeProcessor(
+	Q history.QClaimableBalances,
+	opts ...ClaimableBalancesChangeProcessorOption,
+) *ClaimableBalancesChangeProcessor {
+	var cfg claimableBalancesChangeProcessorConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
 
-	return nil
-}
+	seenFilter := cfg.seenFilter
+	// filterPopulated tracks whether seenFilter reflects rows that existed
+	// before this processor was constructed (via WithSeenFilter, typically
+	// built by RebuildClaimableBalanceFilter). Without that, an empty filter
+	// would report every pre-existing balance as absent, and the onRemove
+	// hook below would skip deleting it forever -- a correctness regression
+	// vs. always deleting. Only skip the delete round-trip once the filter
+	// is known to reflect reality.
+	filterPopulated := seenFilter != nil
+	if seenFilter == nil {
+		seenFilter = newCuckooFilter(defaultCuckooFilterCapacity, defaultCuckooFalsePositiveRate)
+	}
 
-func (p *ClaimableBalancesChangeProcessor) Commit(ctx context.Context) error {
-	defer p.reset()
-	var (
-		cbIDsToDelete []string
-	)
-	changes := p.cache.GetChanges()
-	for _, change := range changes {
-		switch {
-		case change.Pre == nil && change.Post != nil:
-			// Created
-			cb, err := p.ledgerEntryToRow(change.Post)
-			if err != nil {
-				return err
-			}
-			// Add claimable balance
-			if err := p.claimableBalanceInsertBuilder.Add(cb); err != nil {
-				return errors.Wrap(err, "error adding to ClaimableBalanceBatchInsertBuilder")
-			}
+	adapter := &claimableBalanceAdapter{
+		qClaimableBalances: Q,
+		encodingBuffer:     xdr.NewEncodingBuffer(),
+		archive:            cfg.archive,
+		ledgerSequence:     cfg.ledgerSequence,
+	}
+	adapter.resetBuilders()
 
-			// Add claimants
+	p := &ClaimableBalancesChangeProcessor{adapter: adapter}
+	p.batch = NewBatchChangeProcessor[history.ClaimableBalance](
+		adapter,
+		cfg.compactorFactory,
+		func(cb history.ClaimableBalance) error {
+			seenFilter.Insert(cb.BalanceID)
 			for _, claimant := range cb.Claimants {
-				claimant := history.ClaimableBalanceClaimant{
+				err := adapter.claimantsInsertBuilder.Add(history.ClaimableBalanceClaimant{
 					BalanceID:          cb.BalanceID,
 					Destination:        claimant.Destination,
 					LastModifiedLedger: cb.LastModifiedLedger,
-				}
-
-				if err := p.claimantsInsertBuilder.Add(claimant); err != nil {
+				})
+				if err != nil {
 					return errors.Wrap(err, "error adding to ClaimableBalanceClaimantBatchInsertBuilder")
 				}
 			}
-		case change.Pre != nil && change.Post == nil:
-			// Removed
-			cBalance := change.Pre.Data.MustClaimableBalance()
-			id, err := p.encodingBuffer.MarshalHex(cBalance.BalanceId)
-			if err != nil {
-				return err
+			return nil
+		},
+		func(id string) (skip bool, err error) {
+			if !filterPopulated {
+				// No filter rebuilt from existing rows was supplied, so we
+				// can't tell "never indexed" from "indexed before this
+				// process started" -- always attempt the delete.
+				return false, nil
 			}
-			cbIDsToDelete = append(cbIDsToDelete, id)
-		default:
-			// claimable balance can only be created or removed
-			return fmt.Errorf("invalid change entry for a claimable balance was detected")
+			// This node never indexed the balance, so there is nothing to
+			// delete; skip the round-trip to Postgres entirely.
+			return !seenFilter.MaybeContains(id), nil
+		},
+		// The filter above is probabilistic: a false positive lets an id
+		// through to the delete batch for a balance this node never
+		// indexed, which legitimately affects zero rows. Tolerate that
+		// instead of treating it as a state error.
+		true,
+	)
+	return p
+}
+
+func (p *ClaimableBalancesChangeProcessor) Name() string {
+	return "processors.ClaimableBalancesChangeProcessor"
+}
+
+func (p *ClaimableBalancesChangeProcessor) ProcessChange(ctx context.Context, change ingest.Change) error {
+	return p.batch.ProcessChange(ctx, change)
+}
+
+func (p *ClaimableBalancesChangeProcessor) Commit(ctx context.Context) error {
+	defer p.adapter.resetBuilders()
+	return p.batch.Commit(ctx)
+}
+
+// claimableBalanceAdapter is the EntryAdapter[history.ClaimableBalance] that
+// drives claimable balance ingestion through BatchChangeProcessor. It owns
+// the batch insert builders so Insert can flush them, and Delete archives
+// (when enabled) before removing rows.
+type claimableBalanceAdapter struct {
+	qClaimableBalances            history.QClaimableBalances
+	encodingBuffer                *xdr.EncodingBuffer
+	claimantsInsertBuilder        history.ClaimableBalanceClaimantBatchInsertBuilder
+	claimableBalanceInsertBuilder history.ClaimableBalanceBatchInsertBuilder
+	archive                       ArchiveConfig
+	ledgerSequence                uint32
+}
+
+func (a *claimableBalanceAdapter) resetBuilders() {
+	a.claimantsInsertBuilder = a.qClaimableBalances.NewClaimableBalanceClaimantBatchInsertBuilder()
+	a.claimableBalanceInsertBuilder = a.qClaimableBalances.NewClaimableBalanceBatchInsertBuilder()
+}
+
+func (a *claimableBalanceAdapter) LedgerEntryType() xdr.LedgerEntryType {
+	return xdr.LedgerEntryTypeClaimableBalance
+}
+
+func (a *claimableBalanceAdapter) ToRow(entry *xdr.LedgerEntry) (history.ClaimableBalance, error) {
+	return claimableBalanceRowFromLedgerEntry(a.encodingBuffer, entry)
+}
+
+// claimableBalanceRowFromLedgerEntry builds the history.ClaimableBalance row
+// for entry. It's shared by claimableBalanceAdapter.ToRow and
+// ClaimableBalanceImporter so an imported row carries the same per-row
+// Sponsor and LastModifiedLedger a live-ingested row would have.
+func claimableBalanceRowFromLedgerEntry(buf *xdr.EncodingBuffer, entry *xdr.LedgerEntry) (history.ClaimableBalance, error) {
+	cBalance := entry.Data.MustClaimableBalance()
+	id, err := buf.MarshalHex(cBalance.BalanceId)
+	if err != nil {
+		return history.ClaimableBalance{}, err
+	}
+	return history.ClaimableBalance{
+		BalanceID:          id,
+		Claimants:          buildClaimants(cBalance.Claimants),
+		Asset:              cBalance.Asset,
+		Amount:             cBalance.Amount,
+		Sponsor:            ledgerEntrySponsorToNullString(*entry),
+		LastModifiedLedger: uint32(entry.LastModifiedLedgerSeq),
+		Flags:              uint32(cBalance.Flags()),
+	}, nil
+}
+
+func (a *claimableBalanceAdapter) RowID(row history.ClaimableBalance) string {
+	return row.BalanceID
+}
+
+func (a *claimableBalanceAdapter) Insert(ctx context.Context, rows []history.ClaimableBalance) error {
+	for _, cb := range rows {
+		if err := a.claimableBalanceInsertBuilder.Add(cb); err != nil {
+			return errors.Wrap(err, "error adding to ClaimableBalanceBatchInsertBuilder")
 		}
 	}
 
-	err := p.claimantsInsertBuilder.Exec(ctx)
-	if err != nil {
+	if err := a.claimantsInsertBuilder.Exec(ctx); err != nil {
 		return errors.Wrap(err, "error executing ClaimableBalanceClaimantBatchInsertBuilder")
 	}
-
-	err = p.claimableBalanceInsertBuilder.Exec(ctx)
-	if err != nil {
+	if err := a.claimableBalanceInsertBuilder.Exec(ctx); err != nil {
 		return errors.Wrap(err, "error executing ClaimableBalanceBatchInsertBuilder")
 	}
+	return nil
+}
 
-	if len(cbIDsToDelete) > 0 {
-		count, err := p.qClaimableBalances.RemoveClaimableBalances(ctx, cbIDsToDelete)
-		if err != nil {
-			return errors.Wrap(err, "error executing removal")
+func (a *claimableBalanceAdapter) Delete(ctx context.Context, ids []string) (int64, error) {
+	if a.archive.Enabled {
+		archiver, ok := a.qClaimableBalances.(ArchivingQClaimableBalances)
+		if !ok {
+			return 0, errors.New("claimable balance archiving is enabled but the configured QClaimableBalances does not implement ArchivingQClaimableBalances")
 		}
-		if count != int64(len(cbIDsToDelete)) {
-			return ingest.NewStateError(errors.Errorf(
-				"%d rows affected when deleting %d claimable balances",
-				count,
-				len(cbIDsToDelete),
-			))
+		if err := archiver.ArchiveClaimableBalances(ctx, ids, a.ledgerSequence); err != nil {
+			return 0, errors.Wrap(err, "error archiving claimable balances")
 		}
+	}
 
-		// Remove ClaimableBalanceClaimants
-		_, err = p.qClaimableBalances.RemoveClaimableBalanceClaimants(ctx, cbIDsToDelete)
-		if err != nil {
-			return errors.Wrap(err, "error executing removal of claimants")
-		}
+	count, err := a.qClaimableBalances.RemoveClaimableBalances(ctx, ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "error executing removal")
 	}
 
-	return nil
+	// Always remove claimants for the full id list, even when count doesn't
+	// match len(ids): under allowPartialDelete, a mismatch only legitimately
+	// arises when the onRemove cuckoo filter let through an id this node
+	// never actually indexed (see NewClaimableBalancesChangeProcessor), and
+	// a balance and its claimant rows are always written together, so an id
+	// that wasn't deleted here never had claimant rows to begin with --
+	// RemoveClaimableBalanceClaimants is a no-op for it. Guarding this call
+	// on count == len(ids) instead would orphan the claimant rows of the ids
+	// that *were* genuinely deleted whenever the batch also contained a
+	// false positive.
+	if _, err := a.qClaimableBalances.RemoveClaimableBalanceClaimants(ctx, ids); err != nil {
+		return 0, errors.Wrap(err, "error executing removal of claimants")
+	}
+
+	return count, nil
 }
 
 func buildClaimants(claimants []xdr.Claimant) history.Claimants {
@@ -146,21 +260,3 @@ func buildClaimants(claimants []xdr.Claimant) history.Claimants {
 	}
 	return hClaimants
 }
-
-func (p *ClaimableBalancesChangeProcessor) ledgerEntryToRow(entry *xdr.LedgerEntry) (history.ClaimableBalance, error) {
-	cBalance := entry.Data.MustClaimableBalance()
-	id, err := xdr.MarshalHex(cBalance.BalanceId)
-	if err != nil {
-		return history.ClaimableBalance{}, err
-	}
-	row := history.ClaimableBalance{
-		BalanceID:          id,
-		Claimants:          buildClaimants(cBalance.Claimants),
-		Asset:              cBalance.Asset,
-		Amount:             cBalance.Amount,
-		Sponsor:            ledgerEntrySponsorToNullString(*entry),
-		LastModifiedLedger: uint32(entry.LastModifiedLedgerSeq),
-		Flags:              uint32(cBalance.Flags()),
-	}
-	return row, nil
-}
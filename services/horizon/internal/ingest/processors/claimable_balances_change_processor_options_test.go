@@ -0,0 +1,35 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimableBalancesChangeProcessorOptionsDefaults(t *testing.T) {
+	var cfg claimableBalancesChangeProcessorConfig
+	assert.Nil(t, cfg.seenFilter)
+	assert.Nil(t, cfg.compactorFactory)
+	assert.False(t, cfg.archive.Enabled)
+	assert.Zero(t, cfg.ledgerSequence)
+}
+
+func TestClaimableBalancesChangeProcessorOptionsApply(t *testing.T) {
+	var cfg claimableBalancesChangeProcessorConfig
+	filter := newCuckooFilter(16, defaultCuckooFalsePositiveRate)
+	factory := NewInMemoryCompactorFactory()
+	archive := ArchiveConfig{Enabled: true, RetentionLedgers: 100}
+
+	for _, opt := range []ClaimableBalancesChangeProcessorOption{
+		WithLedgerSequence(42),
+		WithArchive(archive),
+		WithCompactorFactory(factory),
+		WithSeenFilter(filter),
+	} {
+		opt(&cfg)
+	}
+
+	assert.Equal(t, uint32(42), cfg.ledgerSequence)
+	assert.Equal(t, archive, cfg.archive)
+	assert.Same(t, filter, cfg.seenFilter)
+}
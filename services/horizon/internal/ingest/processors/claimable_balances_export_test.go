@@ -0,0 +1,52 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClaimableBalanceImportHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		line    string
+		want    ClaimableBalanceImportHeader
+		wantErr bool
+	}{
+		{
+			name: "valid header",
+			line: "stellar-cb-export-v1 12345 Test SDF Network ; September 2015",
+			want: ClaimableBalanceImportHeader{
+				LastModifiedLedger: 12345,
+				NetworkPassphrase:  "Test SDF Network ; September 2015",
+			},
+		},
+		{
+			name:    "wrong magic",
+			line:    "not-a-cb-export 12345 some passphrase",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric ledger",
+			line:    "stellar-cb-export-v1 not-a-number some passphrase",
+			wantErr: true,
+		},
+		{
+			name:    "missing passphrase field",
+			line:    "stellar-cb-export-v1 12345",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseClaimableBalanceImportHeader(tc.line)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
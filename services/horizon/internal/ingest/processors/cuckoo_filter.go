@@ -0,0 +1,213 @@
+package processors
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+
+	"github.com/stellar/go/xdr"
+)
+
+const (
+	defaultCuckooBucketSize   = 4
+	defaultCuckooMaxEvictions = 500
+
+	// minFingerprintBits/maxFingerprintBits bound how much of the 8-bit
+	// fingerprint byte newCuckooFilter's false-positive-rate calculation is
+	// allowed to use. 8 is the ceiling because fingerprints are stored as a
+	// single uint8 per slot (the "~8 bits/entry" this filter targets); below
+	// 4 bits the false-positive rate gets too high to be useful.
+	minFingerprintBits = 4
+	maxFingerprintBits = 8
+
+	// defaultCuckooFilterCapacity is sized for a single asset-type subgraph
+	// on a medium-size network; operators with larger CB counts should size
+	// this to roughly their live row count to keep the false-positive rate low.
+	defaultCuckooFilterCapacity    = 1 << 20
+	defaultCuckooFalsePositiveRate = 0.01
+)
+
+// CuckooFilter is a probabilistic "definitely absent / maybe present" set
+// of claimable balance IDs, used to skip a DELETE round-trip to Postgres
+// when a balance id was never actually indexed by this node (e.g. on a
+// partial reingest range). False negatives never occur; false positives
+// happen at a rate set by the fingerprint width newCuckooFilter derives from
+// its falsePositiveRate. That width is capped at maxFingerprintBits, so a
+// requested rate finer than 8-bit fingerprints allow (the 0.01 default
+// among them) is not reached. Callers that rely on this filter to skip work
+// must tolerate false positives (see BatchChangeProcessor's
+// allowPartialDelete).
+type CuckooFilter struct {
+	buckets         [][]uint8
+	bucketSize      int
+	maxEvictions    int
+	fingerprintMask uint8
+}
+
+// newCuckooFilter builds a filter sized for approximately capacity entries
+// at the given false-positive rate (0, 1). Both are operator-configurable
+// so the memory/accuracy trade-off can be tuned per deployment size.
+// falsePositiveRate governs how many of the fingerprint byte's bits are
+// used (standard cuckoo filter sizing: bits = log2(2*bucketSize/rate)),
+// clamped to [minFingerprintBits, maxFingerprintBits] since fingerprints
+// are stored as a single byte.
+func newCuckooFilter(capacity int, falsePositiveRate float64) *CuckooFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	numBuckets := nextPowerOfTwo(capacity / defaultCuckooBucketSize)
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+
+	f := &CuckooFilter{
+		buckets:         make([][]uint8, numBuckets),
+		bucketSize:      defaultCuckooBucketSize,
+		maxEvictions:    defaultCuckooMaxEvictions,
+		fingerprintMask: fingerprintMask(defaultCuckooBucketSize, falsePositiveRate),
+	}
+	for i := range f.buckets {
+		f.buckets[i] = make([]uint8, 0, defaultCuckooBucketSize)
+	}
+	return f
+}
+
+// fingerprintMask returns the bitmask covering the number of fingerprint
+// bits needed to hit falsePositiveRate, clamped to what a uint8 can hold.
+func fingerprintMask(bucketSize int, falsePositiveRate float64) uint8 {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = defaultCuckooFalsePositiveRate
+	}
+	bits := int(math.Ceil(math.Log2(2 * float64(bucketSize) / falsePositiveRate)))
+	if bits < minFingerprintBits {
+		bits = minFingerprintBits
+	}
+	if bits > maxFingerprintBits {
+		bits = maxFingerprintBits
+	}
+	return uint8(1<<uint(bits) - 1)
+}
+
+func (f *CuckooFilter) Insert(balanceID string) bool {
+	fp, i1 := f.fingerprintAndBucket(balanceID)
+	i2 := f.altBucket(i1, fp)
+
+	if f.insertIntoBucket(i1, fp) || f.insertIntoBucket(i2, fp) {
+		return true
+	}
+
+	// Both candidate buckets are full: evict the fingerprint in the bucket's
+	// first slot and relocate it, standard cuckoo-hashing displacement.
+	i := i1
+	var path []int
+	for n := 0; n < f.maxEvictions; n++ {
+		bucket := f.buckets[i]
+		if len(bucket) == 0 {
+			continue
+		}
+		evicted := bucket[0]
+		bucket[0] = fp
+		fp = evicted
+		path = append(path, i)
+		i = f.altBucket(i, fp)
+		if f.insertIntoBucket(i, fp) {
+			return true
+		}
+	}
+	// No free slot was found: undo the displacements so every fingerprint
+	// stored before this call is back in one of its two candidate buckets.
+	for k := len(path) - 1; k >= 0; k-- {
+		bucket := f.buckets[path[k]]
+		bucket[0], fp = fp, bucket[0]
+	}
+	return false
+}
+
+// MaybeContains reports whether balanceID might be in the set. false means
+// it is definitely not present.
+func (f *CuckooFilter) MaybeContains(balanceID string) bool {
+	fp, i1 := f.fingerprintAndBucket(balanceID)
+	i2 := f.altBucket(i1, fp)
+	return bucketContains(f.buckets[i1], fp) || bucketContains(f.buckets[i2], fp)
+}
+
+func (f *CuckooFilter) insertIntoBucket(i int, fp uint8) bool {
+	if len(f.buckets[i]) >= f.bucketSize {
+		return false
+	}
+	f.buckets[i] = append(f.buckets[i], fp)
+	return true
+}
+
+func bucketContains(bucket []uint8, fp uint8) bool {
+	for _, v := range bucket {
+		if v == fp {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) fingerprintAndBucket(balanceID string) (uint8, int) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(balanceID))
+	sum := h.Sum64()
+
+	fp := uint8(sum&0xff)&f.fingerprintMask | 1 // low bit always set, so never 0
+	bucket := int(sum>>8) % len(f.buckets)
+	if bucket < 0 {
+		bucket += len(f.buckets)
+	}
+	return fp, bucket
+}
+
+// altBucket returns the other candidate bucket for a fingerprint already
+// known to live in (or be destined for) bucket i. Buckets are numBuckets
+// derived via nextPowerOfTwo, so reducing the fingerprint hash mod
+// len(f.buckets) *before* XORing with i keeps both operands within
+// [0, len(f.buckets)), which makes altBucket its own inverse:
+// altBucket(altBucket(i, fp), fp) == i. XOR-ing first and reducing mod
+// len(f.buckets) after (as a naive implementation might) breaks that
+// invariant whenever len(f.buckets) isn't itself a power of two that the
+// XOR result falls within, and eviction can then relocate a fingerprint to
+// a bucket neither of MaybeContains's two lookup buckets derives --
+// a false negative, which this filter must never produce.
+func (f *CuckooFilter) altBucket(i int, fp uint8) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte{fp})
+	hfp := int(h.Sum64()) % len(f.buckets)
+	if hfp < 0 {
+		hfp += len(f.buckets)
+	}
+	return i ^ hfp
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// RebuildClaimableBalanceFilter scans every indexed balance id and
+// populates a fresh cuckoo filter, intended to be called once at startup
+// (passed into NewClaimableBalancesChangeProcessor) so the filter reflects
+// rows that existed before this process started.
+func RebuildClaimableBalanceFilter(ctx context.Context, q interface {
+	StreamAllClaimableBalances(ctx context.Context, f func(xdr.ClaimableBalanceEntry) error) error
+}, capacity int, falsePositiveRate float64) (*CuckooFilter, error) {
+	filter := newCuckooFilter(capacity, falsePositiveRate)
+	err := q.StreamAllClaimableBalances(ctx, func(entry xdr.ClaimableBalanceEntry) error {
+		id, err := xdr.MarshalHex(entry.BalanceId)
+		if err != nil {
+			return err
+		}
+		filter.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
@@ -0,0 +1,81 @@
+package processors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCuckooFilterNoFalseNegatives(t *testing.T) {
+	filter := newCuckooFilter(1024, defaultCuckooFalsePositiveRate)
+
+	inserted := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprintf("balance-%d", i)
+		assert.True(t, filter.Insert(id))
+		inserted = append(inserted, id)
+	}
+
+	for _, id := range inserted {
+		assert.True(t, filter.MaybeContains(id), "inserted id must never be reported absent")
+	}
+}
+
+// TestCuckooFilterNoFalseNegativesUnderEviction loads the filter close to
+// capacity so most inserts force at least one eviction, the case altBucket's
+// involution property must hold for: a fingerprint relocated to its "alt"
+// bucket must still be reachable from either of MaybeContains's two lookup
+// buckets for that id.
+func TestCuckooFilterNoFalseNegativesUnderEviction(t *testing.T) {
+	filter := newCuckooFilter(256, defaultCuckooFalsePositiveRate)
+
+	inserted := make([]string, 0, 900)
+	for i := 0; i < 900; i++ {
+		id := fmt.Sprintf("balance-%d", i)
+		if !filter.Insert(id) {
+			break
+		}
+		inserted = append(inserted, id)
+	}
+
+	require.NotEmpty(t, inserted)
+	for _, id := range inserted {
+		assert.True(t, filter.MaybeContains(id), "inserted id must never be reported absent, even after eviction")
+	}
+}
+
+func TestAltBucketIsInvolution(t *testing.T) {
+	filter := newCuckooFilter(1024, defaultCuckooFalsePositiveRate)
+
+	for i := 0; i < len(filter.buckets); i++ {
+		for fp := 0; fp < 256; fp++ {
+			alt := filter.altBucket(i, uint8(fp))
+			assert.Equal(t, i, filter.altBucket(alt, uint8(fp)), "altBucket must be its own inverse")
+		}
+	}
+}
+
+func TestFingerprintMaskRespectsBounds(t *testing.T) {
+	cases := []struct {
+		name string
+		rate float64
+		want uint8
+	}{
+		{"tiny rate clamps to max bits", 0.0000001, 1<<maxFingerprintBits - 1},
+		{"large rate clamps to min bits", 0.9, 1<<minFingerprintBits - 1},
+		// The 0.01 default itself needs more than maxFingerprintBits.
+		{"zero rate falls back to default", 0, 1<<maxFingerprintBits - 1},
+		{"out of range rate falls back to default", 1.5, 1<<maxFingerprintBits - 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mask := fingerprintMask(defaultCuckooBucketSize, tc.rate)
+			assert.NotZero(t, mask)
+			assert.LessOrEqual(t, mask, uint8(1<<maxFingerprintBits-1))
+			assert.Equal(t, tc.want, mask)
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package processors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stellar/go/ingest"
+	"github.com/stellar/go/support/errors"
+	"github.com/stellar/go/xdr"
+)
+
+// redisChangeFieldSeparator joins the encoded parts of a change. "|" never
+// appears in base64 output, so a plain split (rather than fmt.Sscanf, which
+// treats "%s" as a non-whitespace run and would swallow every remaining
+// field) is enough to recover the three parts unambiguously.
+const redisChangeFieldSeparator = "|"
+
+// redisHasher is the subset of a Redis hash-commands client RedisChangeCompactor
+// needs. Its methods return plain values and errors rather than go-redis's
+// *Cmd results, so *redis.Client from github.com/redis/go-redis/v9 needs a
+// thin wrapper to satisfy it; it is kept narrow here so the compactor can be
+// unit tested against a fake.
+type redisHasher interface {
+	HSet(ctx context.Context, key, field string, value []byte) error
+	// HGet reads a single field, reporting ok=false if the field (or the
+	// whole hash) doesn't exist yet, analogous to Go's "value, ok" map read.
+	HGet(ctx context.Context, key, field string) (value []byte, ok bool, err error)
+	HGetAll(ctx context.Context, key string) (map[string][]byte, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+}
+
+// RedisChangeCompactor stores pending change entries in a Redis hash keyed
+// by LedgerKey, so multiple horizon-ingest workers given the same hashKey
+// (e.g. processes splitting the same ledger range) share and deduplicate
+// one buffer in Redis instead of each buffering its own copy in memory.
+type RedisChangeCompactor struct {
+	ctx            context.Context
+	client         redisHasher
+	hashKey        string
+	encodingBuffer *xdr.EncodingBuffer
+	// seen tracks the distinct LedgerKey fields this instance has written,
+	// so Size() reports the number of distinct pending entries rather than
+	// the number of AddChange calls (which overcounts every coalescing
+	// update to an already-pending entry).
+	seen map[string]struct{}
+}
+
+// NewRedisChangeCompactor returns a ChangeCompactor backed by the given
+// Redis client. hashKey should be unique per logical ingest worker group
+// (e.g. per ledger range or shard) so unrelated compactors don't collide.
+func NewRedisChangeCompactor(ctx context.Context, client redisHasher, hashKey string) *RedisChangeCompactor {
+	return &RedisChangeCompactor{
+		ctx:            ctx,
+		client:         client,
+		hashKey:        hashKey,
+		encodingBuffer: xdr.NewEncodingBuffer(),
+	}
+}
+
+func (c *RedisChangeCompactor) AddChange(change ingest.Change) error {
+	key, err := redisChangeFieldKey(change)
+	if err != nil {
+		return err
+	}
+
+	merged, err := c.mergeWithExisting(key, change)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := encodeChange(c.encodingBuffer, merged)
+	if err != nil {
+		return errors.Wrap(err, "error encoding change for redis")
+	}
+
+	if err := c.client.HSet(c.ctx, c.hashKey, key, encoded); err != nil {
+		return errors.Wrap(err, "error writing change to redis")
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]struct{})
+	}
+	c.seen[key] = struct{}{}
+	return nil
+}
+
+func (c *RedisChangeCompactor) mergeWithExisting(key string, change ingest.Change) (ingest.Change, error) {
+	raw, ok, err := c.client.HGet(c.ctx, c.hashKey, key)
+	if err != nil {
+		return ingest.Change{}, errors.Wrap(err, "error reading existing change from redis")
+	}
+	if !ok {
+		return change, nil
+	}
+
+	prior, err := decodeChange(raw)
+	if err != nil {
+		return ingest.Change{}, errors.Wrap(err, "error decoding existing change from redis")
+	}
+
+	// Keep the original pre-image but take the new post-image, matching
+	// ingest.ChangeCompactor's coalescing behavior.
+	return ingest.Change{
+		Type: change.Type,
+		Pre:  prior.Pre,
+		Post: change.Post,
+	}, nil
+}
+
+func (c *RedisChangeCompactor) GetChanges() ([]ingest.Change, error) {
+	all, err := c.client.HGetAll(c.ctx, c.hashKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading changes from redis")
+	}
+
+	changes := make([]ingest.Change, 0, len(all))
+	for field, raw := range all {
+		change, err := decodeChange(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding change for field %q", field)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (c *RedisChangeCompactor) Size() int {
+	return len(c.seen)
+}
+
+// NewRedisCompactorFactory returns a CompactorFactory that gives every
+// commit cycle its own hash, namespaced under keyPrefix plus a random
+// instance id generated once per factory. The instance id is what keeps two
+// factories (e.g. one per process) from both starting their generation
+// counter at 1 and colliding on the same hash key; it does NOT give
+// processes a way to share a hash with each other. Workers that actually
+// need to share and dedupe one buffer across processes (RedisChangeCompactor's
+// use case) must be handed the same hashKey directly via
+// NewRedisChangeCompactor, out of band from this factory.
+func NewRedisCompactorFactory(ctx context.Context, client redisHasher, keyPrefix string) CompactorFactory {
+	instanceID := randomHex(8)
+	generation := 0
+	return func() ChangeCompactor {
+		generation++
+		return NewRedisChangeCompactor(ctx, client, fmt.Sprintf("%s:%s:%d", keyPrefix, instanceID, generation))
+	}
+}
+
+// randomHex returns a random hex string n bytes wide, used to give each
+// NewRedisCompactorFactory call an instance id distinct from every other
+// process's.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	// crypto/rand.Read on the package-level Reader only errors if the
+	// system's entropy source is unavailable, which would already be fatal
+	// well before reaching here; a zeroed fallback just risks a collision
+	// rather than a crash.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func redisChangeFieldKey(change ingest.Change) (string, error) {
+	var entry *xdr.LedgerEntry
+	if change.Post != nil {
+		entry = change.Post
+	} else {
+		entry = change.Pre
+	}
+	if entry == nil {
+		return "", errors.New("change has no Pre or Post entry")
+	}
+
+	ledgerKey, err := entry.LedgerKey()
+	if err != nil {
+		return "", errors.Wrap(err, "error deriving LedgerKey")
+	}
+
+	return xdr.MarshalBase64(ledgerKey)
+}
+
+func encodeChange(buf *xdr.EncodingBuffer, change ingest.Change) (value []byte, err error) {
+	encodedPre, err := marshalOptionalEntry(buf, change.Pre)
+	if err != nil {
+		return nil, err
+	}
+	encodedPost, err := marshalOptionalEntry(buf, change.Post)
+	if err != nil {
+		return nil, err
+	}
+	fields := []string{
+		strconv.Itoa(int(change.Type)),
+		encodedPre,
+		encodedPost,
+	}
+	return []byte(strings.Join(fields, redisChangeFieldSeparator)), nil
+}
+
+func decodeChange(raw []byte) (ingest.Change, error) {
+	fields := strings.SplitN(string(raw), redisChangeFieldSeparator, 3)
+	if len(fields) != 3 {
+		return ingest.Change{}, errors.Errorf("malformed stored change: expected 3 fields, got %d", len(fields))
+	}
+
+	changeTypeInt, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return ingest.Change{}, errors.Wrap(err, "error parsing stored change type")
+	}
+	changeType := xdr.LedgerEntryType(changeTypeInt)
+	encodedPre, encodedPost := fields[1], fields[2]
+
+	pre, err := unmarshalOptionalEntry(encodedPre)
+	if err != nil {
+		return ingest.Change{}, err
+	}
+	post, err := unmarshalOptionalEntry(encodedPost)
+	if err != nil {
+		return ingest.Change{}, err
+	}
+
+	return ingest.Change{Type: changeType, Pre: pre, Post: post}, nil
+}
+
+func marshalOptionalEntry(buf *xdr.EncodingBuffer, entry *xdr.LedgerEntry) (string, error) {
+	if entry == nil {
+		return "-", nil
+	}
+	return buf.MarshalBase64(entry)
+}
+
+func unmarshalOptionalEntry(encoded string) (*xdr.LedgerEntry, error) {
+	if encoded == "-" {
+		return nil, nil
+	}
+	var entry xdr.LedgerEntry
+	if err := xdr.SafeUnmarshalBase64(encoded, &entry); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling stored entry")
+	}
+	return &entry, nil
+}
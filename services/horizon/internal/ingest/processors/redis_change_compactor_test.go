@@ -0,0 +1,164 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/go/ingest"
+	"github.com/stellar/go/xdr"
+)
+
+// fakeRedisHasher is an in-memory stand-in for a single Redis hash, enough
+// to exercise RedisChangeCompactor without a real Redis server.
+type fakeRedisHasher struct {
+	hashes map[string]map[string][]byte
+}
+
+func newFakeRedisHasher() *fakeRedisHasher {
+	return &fakeRedisHasher{hashes: make(map[string]map[string][]byte)}
+}
+
+func (f *fakeRedisHasher) HSet(ctx context.Context, key, field string, value []byte) error {
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string][]byte)
+	}
+	f.hashes[key][field] = value
+	return nil
+}
+
+func (f *fakeRedisHasher) HGet(ctx context.Context, key, field string) ([]byte, bool, error) {
+	hash, ok := f.hashes[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value, ok := hash[field]
+	return value, ok, nil
+}
+
+func (f *fakeRedisHasher) HGetAll(ctx context.Context, key string) (map[string][]byte, error) {
+	return f.hashes[key], nil
+}
+
+func (f *fakeRedisHasher) HDel(ctx context.Context, key string, fields ...string) error {
+	for _, field := range fields {
+		delete(f.hashes[key], field)
+	}
+	return nil
+}
+
+func TestEncodeDecodeChangeRoundTrip(t *testing.T) {
+	buf := xdr.NewEncodingBuffer()
+
+	entry := &xdr.LedgerEntry{
+		LastModifiedLedgerSeq: 123,
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: xdr.ClaimableBalanceId{
+					Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0,
+					V0:   &xdr.Hash{1, 2, 3},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		change ingest.Change
+	}{
+		{"created", ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: nil, Post: entry}},
+		{"removed", ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: entry, Post: nil}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := encodeChange(buf, tc.change)
+			assert.NoError(t, err)
+
+			decoded, err := decodeChange(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.change.Type, decoded.Type)
+			assert.Equal(t, tc.change.Pre == nil, decoded.Pre == nil)
+			assert.Equal(t, tc.change.Post == nil, decoded.Post == nil)
+		})
+	}
+}
+
+func TestRedisChangeCompactorSizeCountsDistinctEntries(t *testing.T) {
+	client := newFakeRedisHasher()
+	ctx := context.Background()
+	c := NewRedisChangeCompactor(ctx, client, "test-hash")
+
+	entryA := &xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{1}},
+			},
+		},
+	}
+	entryB := &xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{2}},
+			},
+		},
+	}
+
+	assert.NoError(t, c.AddChange(ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: nil, Post: entryA}))
+	assert.Equal(t, 1, c.Size())
+
+	// A second update coalescing into the same LedgerKey must not inflate
+	// Size() past the number of distinct pending entries.
+	assert.NoError(t, c.AddChange(ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: entryA, Post: entryA}))
+	assert.Equal(t, 1, c.Size())
+
+	assert.NoError(t, c.AddChange(ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: nil, Post: entryB}))
+	assert.Equal(t, 2, c.Size())
+}
+
+func TestRedisChangeCompactorMergeWithExistingUsesSingleFieldLookup(t *testing.T) {
+	client := newFakeRedisHasher()
+	ctx := context.Background()
+	c := NewRedisChangeCompactor(ctx, client, "test-hash")
+
+	entry := &xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{9}},
+			},
+		},
+	}
+
+	assert.NoError(t, c.AddChange(ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: nil, Post: entry}))
+	assert.NoError(t, c.AddChange(ingest.Change{Type: xdr.LedgerEntryTypeClaimableBalance, Pre: entry, Post: nil}))
+
+	changes, err := c.GetChanges()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	// The (nil) pre-image from the first AddChange must have been preserved
+	// by mergeWithExisting rather than replaced by the second's non-nil Pre,
+	// and the (nil) post-image from the second applied: a create followed by
+	// a remove nets out to a change with neither side set.
+	assert.Nil(t, changes[0].Pre)
+	assert.Nil(t, changes[0].Post)
+}
+
+func TestNewRedisCompactorFactoryInstancesDontCollide(t *testing.T) {
+	client := newFakeRedisHasher()
+	ctx := context.Background()
+
+	factoryA := NewRedisCompactorFactory(ctx, client, "shared-prefix")
+	factoryB := NewRedisCompactorFactory(ctx, client, "shared-prefix")
+
+	keyA := factoryA().(*RedisChangeCompactor).hashKey
+	keyB := factoryB().(*RedisChangeCompactor).hashKey
+
+	assert.NotEqual(t, keyA, keyB, "separate factories must not produce the same generation-1 hash key")
+	assert.True(t, strings.HasPrefix(keyA, "shared-prefix:"))
+}